@@ -2,6 +2,7 @@ package isom
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/nareix/bits"
 	"io"
@@ -67,6 +68,55 @@ type MPEG4AudioConfig struct {
 	ObjectType      uint
 	SampleRateIndex uint
 	ChannelConfig   uint
+
+	// SampleRateIndexEscaped is true when SampleRateIndex was read from the
+	// 24-bit escape (raw nibble 15) rather than the 4-bit sampleRateTable
+	// index, i.e. it is a Hz value rather than a table index.
+	SampleRateIndexEscaped bool
+
+	// Explicit backward-compatible SBR/PS signaling (HE-AAC/HE-AACv2), present
+	// when ExtensionObjectType != 0.
+	ExtensionObjectType      uint
+	ExtensionSampleRateIndex uint
+	ExtensionSampleRate      int
+	PSPresent                bool
+
+	// PCE carries the channel layout when ChannelConfig == 0, i.e. it is sent
+	// out-of-band as an inline Program Config Element rather than via the
+	// channelConfigTable.
+	PCE *PCE
+}
+
+// PCEChannelElement is a front/side/back channel element of a Program
+// Config Element: either a channel pair (IsCPE) or a single channel.
+type PCEChannelElement struct {
+	IsCPE bool
+	Tag   uint
+}
+
+// PCECCElement is a coupling channel element of a Program Config Element.
+type PCECCElement struct {
+	IndependentlySwitched bool
+	Tag                   uint
+}
+
+// PCE is a Program Config Element, the inline description of the channel
+// layout used when MPEG4AudioConfig.ChannelConfig == 0.
+type PCE struct {
+	ElementInstanceTag     uint
+	ObjectType             uint
+	SamplingFrequencyIndex uint
+
+	FrontElements []PCEChannelElement
+	SideElements  []PCEChannelElement
+	BackElements  []PCEChannelElement
+	LFEElements   []uint
+	AssocElements []uint
+	CCElements    []PCECCElement
+
+	// ChannelCount is derived while parsing: 1 per single channel element
+	// and LFE element, 2 per channel-pair element.
+	ChannelCount int
 }
 
 var sampleRateTable = []int{
@@ -78,7 +128,44 @@ var chanConfigTable = []int{
 	0, 1, 2, 3, 4, 5, 6, 8,
 }
 
-func ReadADTSHeader(data []byte) (objectType, sampleRateIndex, chanConfig, frameLength uint) {
+// Sentinel errors returned while parsing ADTS headers and
+// AudioSpecificConfigs.
+var (
+	ErrADTSBadSync              = errors.New("isom: adts sync word not found")
+	ErrADTSReservedSampleRate   = errors.New("isom: reserved mpeg-4 sampling frequency index")
+	ErrASCReservedChannelConfig = errors.New("isom: reserved channel configuration")
+	ErrASCTruncated             = errors.New("isom: audio specific config truncated")
+)
+
+// Lenient controls whether what is parsed gets validated: when false,
+// ReadMPEG4AudioConfig calls MPEG4AudioConfig.Validate, ReadADTSHeader runs
+// the same checks except the PCE requirement for a ChannelConfig of 0, and
+// SplitADTS also cross-checks every frame against a reference config. It
+// defaults to true for backward compatibility with streams carrying
+// reserved-but-harmless values; set Lenient = false to reject them instead.
+var Lenient = true
+
+// ADTSHeader is the fully parsed form of a 7- or 9-byte raw ADTS header.
+// Its ObjectType, SampleRateIndex and ChannelConfig round-trip through
+// WriteADTSHeader (wrapped in a MPEG4AudioConfig).
+type ADTSHeader struct {
+	MPEGVersion      uint // 0 for MPEG-4, 1 for MPEG-2
+	ProtectionAbsent bool
+	ObjectType       uint
+	SampleRateIndex  uint
+	ChannelConfig    uint
+	FrameLength      uint // includes the 7 or 9 header bytes
+	BufferFullness   uint
+	NumRawDataBlocks uint   // RDBs in frame minus 1
+	CRC              uint16 // only valid when !ProtectionAbsent
+}
+
+func ReadADTSHeader(data []byte) (hdr ADTSHeader, err error) {
+	if len(data) < 7 {
+		err = ErrASCTruncated
+		return
+	}
+
 	br := &bits.Reader{R: bytes.NewReader(data)}
 
 	//Structure
@@ -86,58 +173,267 @@ func ReadADTSHeader(data []byte) (objectType, sampleRateIndex, chanConfig, frame
 	//Header consists of 7 or 9 bytes (without or with CRC).
 
 	//A	12	syncword 0xFFF, all bits must be 1
-	br.ReadBits(12)
+	var sync uint
+	if sync, err = br.ReadBits(12); err != nil {
+		return
+	}
+	if sync != 0xfff {
+		err = ErrADTSBadSync
+		return
+	}
 	//B	1	MPEG Version: 0 for MPEG-4, 1 for MPEG-2
-	br.ReadBits(1)
+	if hdr.MPEGVersion, err = br.ReadBits(1); err != nil {
+		return
+	}
 	//C	2	Layer: always 0
-	br.ReadBits(2)
+	if _, err = br.ReadBits(2); err != nil {
+		return
+	}
 	//D	1	protection absent, Warning, set to 1 if there is no CRC and 0 if there is CRC
-	br.ReadBits(1)
+	var protectionAbsent uint
+	if protectionAbsent, err = br.ReadBits(1); err != nil {
+		return
+	}
+	hdr.ProtectionAbsent = protectionAbsent == 1
 
 	//E	2	profile, the MPEG-4 Audio Object Type minus 1
-	objectType, _ = br.ReadBits(2)
-	objectType++
+	var objectType uint
+	if objectType, err = br.ReadBits(2); err != nil {
+		return
+	}
+	hdr.ObjectType = objectType + 1
 	//F	4	MPEG-4 Sampling Frequency Index (15 is forbidden)
-	sampleRateIndex, _ = br.ReadBits(4)
+	if hdr.SampleRateIndex, err = br.ReadBits(4); err != nil {
+		return
+	}
 	//G	1	private bit, guaranteed never to be used by MPEG, set to 0 when encoding, ignore when decoding
-	br.ReadBits(1)
+	if _, err = br.ReadBits(1); err != nil {
+		return
+	}
 	//H	3	MPEG-4 Channel Configuration (in the case of 0, the channel configuration is sent via an inband PCE)
-	chanConfig, _ = br.ReadBits(3)
-	//I	1	originality, set to 0 when encoding, ignore when decoding
-	br.ReadBits(1)
-	//J	1	home, set to 0 when encoding, ignore when decoding
-	br.ReadBits(1)
-	//K	1	copyrighted id bit, the next bit of a centrally registered copyright identifier, set to 0 when encoding, ignore when decoding
-	br.ReadBits(1)
+	if hdr.ChannelConfig, err = br.ReadBits(3); err != nil {
+		return
+	}
+	//I	1	originality, J 1 home, K 1 copyrighted id bit
+	if _, err = br.ReadBits(3); err != nil {
+		return
+	}
 	//L	1	copyright id start, signals that this frame's copyright id bit is the first bit of the copyright id, set to 0 when encoding, ignore when decoding
-	br.ReadBits(1)
+	if _, err = br.ReadBits(1); err != nil {
+		return
+	}
 
 	//M	13	frame length, this value must include 7 or 9 bytes of header length: FrameLength = (ProtectionAbsent == 1 ? 7 : 9) + size(AACFrame)
-	frameLength, _ = br.ReadBits(13)
+	if hdr.FrameLength, err = br.ReadBits(13); err != nil {
+		return
+	}
 	//O	11	Buffer fullness
-	br.ReadBits(11)
+	if hdr.BufferFullness, err = br.ReadBits(11); err != nil {
+		return
+	}
 	//P	2	Number of AAC frames (RDBs) in ADTS frame minus 1, for maximum compatibility always use 1 AAC frame per ADTS frame
-	br.ReadBits(2)
+	if hdr.NumRawDataBlocks, err = br.ReadBits(2); err != nil {
+		return
+	}
 
 	//Q	16	CRC if protection absent is 0
+	if !hdr.ProtectionAbsent {
+		if len(data) < 9 {
+			err = ErrASCTruncated
+			return
+		}
+		hdr.CRC = uint16(data[7])<<8 | uint16(data[8])
+	}
+
+	if !Lenient {
+		// The ADTS fixed header carries no raw_data_block, so any inline PCE
+		// for a ChannelConfig of 0 is out of reach here; validateBasic skips
+		// that check rather than rejecting every such frame.
+		config := MPEG4AudioConfig{
+			ObjectType:      hdr.ObjectType,
+			SampleRateIndex: hdr.SampleRateIndex,
+			ChannelConfig:   hdr.ChannelConfig,
+		}
+		if err = config.validateBasic(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// WriteADTSHeader writes a 7-byte ADTS header (no CRC) describing a frame
+// whose AAC payload is payloadLen bytes long.
+func WriteADTSHeader(w io.Writer, config MPEG4AudioConfig, payloadLen int) (err error) {
+	bw := &bits.Writer{W: w}
+
+	//A	12	syncword 0xFFF, all bits must be 1
+	if err = bw.WriteBits(0xfff, 12); err != nil {
+		return
+	}
+	//B	1	MPEG Version: 0 for MPEG-4, 1 for MPEG-2
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//C	2	Layer: always 0
+	if err = bw.WriteBits(0, 2); err != nil {
+		return
+	}
+	//D	1	protection absent, set to 1 since we never emit a CRC
+	if err = bw.WriteBits(1, 1); err != nil {
+		return
+	}
+	//E	2	profile, the MPEG-4 Audio Object Type minus 1
+	if err = bw.WriteBits(config.ObjectType-1, 2); err != nil {
+		return
+	}
+	//F	4	MPEG-4 Sampling Frequency Index
+	if err = bw.WriteBits(config.SampleRateIndex, 4); err != nil {
+		return
+	}
+	//G	1	private bit
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//H	3	MPEG-4 Channel Configuration
+	if err = bw.WriteBits(config.ChannelConfig, 3); err != nil {
+		return
+	}
+	//I	1	originality
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//J	1	home
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//K	1	copyrighted id bit
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//L	1	copyright id start
+	if err = bw.WriteBits(0, 1); err != nil {
+		return
+	}
+	//M	13	frame length, includes the 7 header bytes
+	if err = bw.WriteBits(uint(7+payloadLen), 13); err != nil {
+		return
+	}
+	//O	11	Buffer fullness, 0x7FF means variable bitrate
+	if err = bw.WriteBits(0x7ff, 11); err != nil {
+		return
+	}
+	//P	2	Number of AAC frames (RDBs) in ADTS frame minus 1
+	if err = bw.WriteBits(0, 2); err != nil {
+		return
+	}
+
+	if err = bw.FlushBits(); err != nil {
+		return
+	}
+	return
+}
+
+// ADTSToMPEG4AudioConfig parses a 7- or 9-byte ADTS header and returns the
+// equivalent MPEG4AudioConfig (as accepted by WriteElemStreamDescAAC) along
+// with the ADTS frame length, which includes the header itself.
+func ADTSToMPEG4AudioConfig(data []byte) (config MPEG4AudioConfig, frameLen int, err error) {
+	var hdr ADTSHeader
+	if hdr, err = ReadADTSHeader(data); err != nil {
+		return
+	}
+	config = MPEG4AudioConfig{
+		ObjectType:      hdr.ObjectType,
+		SampleRateIndex: hdr.SampleRateIndex,
+		ChannelConfig:   hdr.ChannelConfig,
+	}.Complete()
+	frameLen = int(hdr.FrameLength)
 	return
 }
 
-func readObjectType(r *bits.Reader) (objectType uint, err error) {
+// SplitADTS reads a raw ADTS stream and invokes cb once per frame with the
+// frame's MPEG4AudioConfig and its AAC payload, the inverse of
+// WriteADTSHeader. It stops and returns nil at io.EOF on a frame boundary.
+// Unless Lenient, every frame must share its profile, sample rate index and
+// channel config with ref, rejecting frames that don't match; if ref is nil,
+// the first frame read is used as the reference instead, rejecting streams
+// that switch configuration mid-stream. ref is typically an ASC already read
+// from elsewhere (e.g. an MP4 esds box) when demuxing a stream that mixes
+// raw ADTS frames with out-of-band configuration.
+func SplitADTS(r io.Reader, ref *MPEG4AudioConfig, cb func(config MPEG4AudioConfig, payload []byte) error) (err error) {
+	hdr := make([]byte, 9)
+	stream := ref
+	for {
+		if _, err = io.ReadFull(r, hdr[:7]); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+
+		// protection_absent (bit D, the LSB of the second header byte) is 0
+		// when a 16-bit CRC follows the 7-byte header, making it 9 bytes long.
+		headerLen := 7
+		if hdr[1]&0x01 == 0 {
+			if _, err = io.ReadFull(r, hdr[7:9]); err != nil {
+				return
+			}
+			headerLen = 9
+		}
+
+		var config MPEG4AudioConfig
+		var frameLen int
+		if config, frameLen, err = ADTSToMPEG4AudioConfig(hdr[:headerLen]); err != nil {
+			return
+		}
+
+		if !Lenient {
+			if stream == nil {
+				stream = &config
+			} else if config.ObjectType != stream.ObjectType ||
+				config.SampleRateIndex != stream.SampleRateIndex ||
+				config.ChannelConfig != stream.ChannelConfig {
+				err = fmt.Errorf("isom: adts frame config does not match stream")
+				return
+			}
+		}
+
+		payloadLen := frameLen - headerLen
+		if payloadLen < 0 {
+			err = fmt.Errorf("isom: adts invalid frame length")
+			return
+		}
+		payload := make([]byte, payloadLen)
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return
+		}
+
+		if err = cb(config, payload); err != nil {
+			return
+		}
+	}
+}
+
+// readObjectType also returns the number of bits consumed, since callers need
+// to track the bit position to byte-align before an inline Program Config
+// Element.
+func readObjectType(r *bits.Reader) (objectType uint, n uint, err error) {
 	if objectType, err = r.ReadBits(5); err != nil {
 		return
 	}
+	n = 5
 	if objectType == AOT_ESCAPE {
 		var i uint
 		if i, err = r.ReadBits(6); err != nil {
 			return
 		}
 		objectType = 32 + i
+		n += 6
 	}
 	return
 }
 
-func writeObjectType(w *bits.Writer, objectType uint) (err error) {
+func writeObjectType(w *bits.Writer, objectType uint) (n uint, err error) {
 	if objectType >= 32 {
 		if err = w.WriteBits(AOT_ESCAPE, 5); err != nil {
 			return
@@ -145,27 +441,32 @@ func writeObjectType(w *bits.Writer, objectType uint) (err error) {
 		if err = w.WriteBits(objectType-32, 6); err != nil {
 			return
 		}
+		n = 11
 	} else {
 		if err = w.WriteBits(objectType, 5); err != nil {
 			return
 		}
+		n = 5
 	}
 	return
 }
 
-func readSampleRateIndex(r *bits.Reader) (index uint, err error) {
+func readSampleRateIndex(r *bits.Reader) (index uint, escaped bool, n uint, err error) {
 	if index, err = r.ReadBits(4); err != nil {
 		return
 	}
+	n = 4
 	if index == 0xf {
 		if index, err = r.ReadBits(24); err != nil {
 			return
 		}
+		escaped = true
+		n += 24
 	}
 	return
 }
 
-func writeSampleRateIndex(w *bits.Writer, index uint) (err error) {
+func writeSampleRateIndex(w *bits.Writer, index uint) (n uint, err error) {
 	if index >= 0xf {
 		if err = w.WriteBits(0xf, 4); err != nil {
 			return
@@ -173,10 +474,12 @@ func writeSampleRateIndex(w *bits.Writer, index uint) (err error) {
 		if err = w.WriteBits(index, 24); err != nil {
 			return
 		}
+		n = 28
 	} else {
 		if err = w.WriteBits(index, 4); err != nil {
 			return
 		}
+		n = 4
 	}
 	return
 }
@@ -186,9 +489,355 @@ func (self MPEG4AudioConfig) Complete() (config MPEG4AudioConfig) {
 	if int(config.SampleRateIndex) < len(sampleRateTable) {
 		config.SampleRate = sampleRateTable[config.SampleRateIndex]
 	}
-	if int(config.ChannelConfig) < len(chanConfigTable) {
+	if config.ChannelConfig == 0 && config.PCE != nil {
+		config.ChannelCount = config.PCE.ChannelCount
+	} else if int(config.ChannelConfig) < len(chanConfigTable) {
 		config.ChannelCount = chanConfigTable[config.ChannelConfig]
 	}
+	if config.ExtensionObjectType != 0 && int(config.ExtensionSampleRateIndex) < len(sampleRateTable) {
+		config.ExtensionSampleRate = sampleRateTable[config.ExtensionSampleRateIndex]
+	}
+	return
+}
+
+// validObjectTypes holds every AOT_* constant above; any other object type
+// is reserved as far as this package is concerned.
+var validObjectTypes = map[uint]bool{
+	AOT_AAC_MAIN: true, AOT_AAC_LC: true, AOT_AAC_SSR: true, AOT_AAC_LTP: true,
+	AOT_SBR: true, AOT_AAC_SCALABLE: true, AOT_TWINVQ: true, AOT_CELP: true,
+	AOT_HVXC: true, AOT_TTSI: true, AOT_MAINSYNTH: true, AOT_WAVESYNTH: true,
+	AOT_MIDI: true, AOT_SAFX: true, AOT_ER_AAC_LC: true, AOT_ER_AAC_LTP: true,
+	AOT_ER_AAC_SCALABLE: true, AOT_ER_TWINVQ: true, AOT_ER_BSAC: true,
+	AOT_ER_AAC_LD: true, AOT_ER_CELP: true, AOT_ER_HVXC: true, AOT_ER_HILN: true,
+	AOT_ER_PARAM: true, AOT_SSC: true, AOT_PS: true, AOT_SURROUND: true,
+	AOT_ESCAPE: true, AOT_L1: true, AOT_L2: true, AOT_L3: true, AOT_DST: true,
+	AOT_ALS: true, AOT_SLS: true, AOT_SLS_NON_CORE: true, AOT_ER_AAC_ELD: true,
+	AOT_SMR_SIMPLE: true, AOT_SMR_MAIN: true, AOT_USAC_NOSBR: true, AOT_SAOC: true,
+	AOT_LD_SURROUND: true, AOT_USAC: true,
+}
+
+// validateBasic rejects reserved Audio Object Types and reserved sampling
+// frequency indices. It does not check ChannelConfig == 0 against a PCE,
+// since some callers (ADTS's fixed header) have no raw_data_block to carry
+// one; see Validate for the fuller check.
+func (self MPEG4AudioConfig) validateBasic() (err error) {
+	if !validObjectTypes[self.ObjectType] {
+		return fmt.Errorf("isom: reserved audio object type %d", self.ObjectType)
+	}
+	if !self.SampleRateIndexEscaped && int(self.SampleRateIndex) >= len(sampleRateTable) {
+		return ErrADTSReservedSampleRate
+	}
+	if self.ChannelConfig != 0 && (int(self.ChannelConfig) >= len(chanConfigTable) || chanConfigTable[self.ChannelConfig] == 0) {
+		return ErrASCReservedChannelConfig
+	}
+	return nil
+}
+
+// Validate rejects reserved Audio Object Types, reserved sampling frequency
+// indices, and channel configurations that can't produce a nonzero channel
+// count (a channelConfig of 0 requires an attached PCE). It is used by
+// ReadMPEG4AudioConfig to opt into strict parsing; see Lenient.
+func (self MPEG4AudioConfig) Validate() (err error) {
+	if err = self.validateBasic(); err != nil {
+		return
+	}
+	if self.ChannelConfig == 0 && (self.PCE == nil || self.PCE.ChannelCount == 0) {
+		return ErrASCReservedChannelConfig
+	}
+	return nil
+}
+
+// 11-bit syncExtensionType values that introduce explicit backward-compatible
+// SBR/PS signaling in AudioSpecificConfig.
+const (
+	syncExtensionTypeSBR = 0x2b7
+	syncExtensionTypePS  = 0x548
+)
+
+// alignReadBits discards bits up to the next byte boundary, given the number
+// of bits already read from the start of the AudioSpecificConfig.
+func alignReadBits(r *bits.Reader, pos *uint) (err error) {
+	if rem := *pos % 8; rem != 0 {
+		pad := 8 - rem
+		if _, err = r.ReadBits(pad); err != nil {
+			return
+		}
+		*pos += pad
+	}
+	return
+}
+
+// alignWriteBits pads with zero bits up to the next byte boundary.
+func alignWriteBits(w *bits.Writer, pos *uint) (err error) {
+	if rem := *pos % 8; rem != 0 {
+		pad := 8 - rem
+		if err = w.WriteBits(0, pad); err != nil {
+			return
+		}
+		*pos += pad
+	}
+	return
+}
+
+// readPCE parses a Program Config Element, as found byte-aligned after
+// AudioSpecificConfig's channelConfig when channelConfig == 0. pos tracks the
+// bit position from the start of the AudioSpecificConfig so the
+// comment_field_bytes that follows the element list can be byte-aligned too.
+func readPCE(r *bits.Reader, pos *uint) (pce PCE, err error) {
+	read := func(n uint) (v uint, err error) {
+		if v, err = r.ReadBits(n); err != nil {
+			return
+		}
+		*pos += n
+		return
+	}
+
+	if pce.ElementInstanceTag, err = read(4); err != nil {
+		return
+	}
+	if pce.ObjectType, err = read(2); err != nil {
+		return
+	}
+	if pce.SamplingFrequencyIndex, err = read(4); err != nil {
+		return
+	}
+
+	var numFront, numSide, numBack, numLFE, numAssoc, numCC uint
+	if numFront, err = read(4); err != nil {
+		return
+	}
+	if numSide, err = read(4); err != nil {
+		return
+	}
+	if numBack, err = read(4); err != nil {
+		return
+	}
+	if numLFE, err = read(2); err != nil {
+		return
+	}
+	if numAssoc, err = read(3); err != nil {
+		return
+	}
+	if numCC, err = read(4); err != nil {
+		return
+	}
+
+	var monoMixdownPresent uint
+	if monoMixdownPresent, err = read(1); err != nil {
+		return
+	}
+	if monoMixdownPresent == 1 {
+		if _, err = read(4); err != nil {
+			return
+		}
+	}
+
+	var stereoMixdownPresent uint
+	if stereoMixdownPresent, err = read(1); err != nil {
+		return
+	}
+	if stereoMixdownPresent == 1 {
+		if _, err = read(4); err != nil {
+			return
+		}
+	}
+
+	var matrixMixdownPresent uint
+	if matrixMixdownPresent, err = read(1); err != nil {
+		return
+	}
+	if matrixMixdownPresent == 1 {
+		if _, err = read(3); err != nil {
+			return
+		}
+	}
+
+	readChanElements := func(num uint) (elems []PCEChannelElement, chans int, err error) {
+		for i := uint(0); i < num; i++ {
+			var isCPE, tag uint
+			if isCPE, err = read(1); err != nil {
+				return
+			}
+			if tag, err = read(4); err != nil {
+				return
+			}
+			elems = append(elems, PCEChannelElement{IsCPE: isCPE == 1, Tag: tag})
+			if isCPE == 1 {
+				chans += 2
+			} else {
+				chans++
+			}
+		}
+		return
+	}
+
+	var chans int
+	if pce.FrontElements, chans, err = readChanElements(numFront); err != nil {
+		return
+	}
+	pce.ChannelCount += chans
+	if pce.SideElements, chans, err = readChanElements(numSide); err != nil {
+		return
+	}
+	pce.ChannelCount += chans
+	if pce.BackElements, chans, err = readChanElements(numBack); err != nil {
+		return
+	}
+	pce.ChannelCount += chans
+
+	for i := uint(0); i < numLFE; i++ {
+		var tag uint
+		if tag, err = read(4); err != nil {
+			return
+		}
+		pce.LFEElements = append(pce.LFEElements, tag)
+		pce.ChannelCount++
+	}
+
+	for i := uint(0); i < numAssoc; i++ {
+		var tag uint
+		if tag, err = read(4); err != nil {
+			return
+		}
+		pce.AssocElements = append(pce.AssocElements, tag)
+	}
+
+	for i := uint(0); i < numCC; i++ {
+		var indSW, tag uint
+		if indSW, err = read(1); err != nil {
+			return
+		}
+		if tag, err = read(4); err != nil {
+			return
+		}
+		pce.CCElements = append(pce.CCElements, PCECCElement{IndependentlySwitched: indSW == 1, Tag: tag})
+	}
+
+	if err = alignReadBits(r, pos); err != nil {
+		return
+	}
+
+	var commentLen uint
+	if commentLen, err = read(8); err != nil {
+		return
+	}
+	for i := uint(0); i < commentLen; i++ {
+		if _, err = read(8); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// writePCE is the inverse of readPCE. It never emits mixdown signaling or a
+// comment field.
+func writePCE(w *bits.Writer, pce PCE, pos *uint) (err error) {
+	write := func(v, n uint) (err error) {
+		if err = w.WriteBits(v, n); err != nil {
+			return
+		}
+		*pos += n
+		return
+	}
+
+	if err = write(pce.ElementInstanceTag, 4); err != nil {
+		return
+	}
+	if err = write(pce.ObjectType, 2); err != nil {
+		return
+	}
+	if err = write(pce.SamplingFrequencyIndex, 4); err != nil {
+		return
+	}
+
+	if err = write(uint(len(pce.FrontElements)), 4); err != nil {
+		return
+	}
+	if err = write(uint(len(pce.SideElements)), 4); err != nil {
+		return
+	}
+	if err = write(uint(len(pce.BackElements)), 4); err != nil {
+		return
+	}
+	if err = write(uint(len(pce.LFEElements)), 2); err != nil {
+		return
+	}
+	if err = write(uint(len(pce.AssocElements)), 3); err != nil {
+		return
+	}
+	if err = write(uint(len(pce.CCElements)), 4); err != nil {
+		return
+	}
+
+	// mono_mixdown_present, stereo_mixdown_present, matrix_mixdown_idx_present
+	if err = write(0, 1); err != nil {
+		return
+	}
+	if err = write(0, 1); err != nil {
+		return
+	}
+	if err = write(0, 1); err != nil {
+		return
+	}
+
+	writeChanElements := func(elems []PCEChannelElement) (err error) {
+		for _, e := range elems {
+			var isCPE uint
+			if e.IsCPE {
+				isCPE = 1
+			}
+			if err = write(isCPE, 1); err != nil {
+				return
+			}
+			if err = write(e.Tag, 4); err != nil {
+				return
+			}
+		}
+		return
+	}
+	if err = writeChanElements(pce.FrontElements); err != nil {
+		return
+	}
+	if err = writeChanElements(pce.SideElements); err != nil {
+		return
+	}
+	if err = writeChanElements(pce.BackElements); err != nil {
+		return
+	}
+
+	for _, tag := range pce.LFEElements {
+		if err = write(tag, 4); err != nil {
+			return
+		}
+	}
+	for _, tag := range pce.AssocElements {
+		if err = write(tag, 4); err != nil {
+			return
+		}
+	}
+	for _, cc := range pce.CCElements {
+		var indSW uint
+		if cc.IndependentlySwitched {
+			indSW = 1
+		}
+		if err = write(indSW, 1); err != nil {
+			return
+		}
+		if err = write(cc.Tag, 4); err != nil {
+			return
+		}
+	}
+
+	if err = alignWriteBits(w, pos); err != nil {
+		return
+	}
+
+	// comment_field_bytes, we never emit a comment
+	if err = write(0, 8); err != nil {
+		return
+	}
+
 	return
 }
 
@@ -196,24 +845,108 @@ func (self MPEG4AudioConfig) Complete() (config MPEG4AudioConfig) {
 func ReadMPEG4AudioConfig(r io.Reader) (config MPEG4AudioConfig, err error) {
 	br := &bits.Reader{R: r}
 
-	if config.ObjectType, err = readObjectType(br); err != nil {
+	var pos, n uint
+	if config.ObjectType, n, err = readObjectType(br); err != nil {
+		err = ErrASCTruncated
 		return
 	}
-	if config.SampleRateIndex, err = readSampleRateIndex(br); err != nil {
+	pos += n
+	if config.SampleRateIndex, config.SampleRateIndexEscaped, n, err = readSampleRateIndex(br); err != nil {
+		err = ErrASCTruncated
 		return
 	}
+	pos += n
 	if config.ChannelConfig, err = br.ReadBits(4); err != nil {
+		err = ErrASCTruncated
 		return
 	}
+	pos += 4
+
+	if config.ChannelConfig == 0 {
+		// The channel layout is carried inline as a Program Config Element
+		// rather than via channelConfigTable.
+		if err = alignReadBits(br, &pos); err != nil {
+			return
+		}
+		var pce PCE
+		if pce, err = readPCE(br, &pos); err != nil {
+			return
+		}
+		config.PCE = &pce
+		if !Lenient {
+			err = config.Validate()
+		}
+		return
+	}
+
+	if !Lenient {
+		if err = config.Validate(); err != nil {
+			return
+		}
+	}
+
+	// Explicit backward-compatible SBR/PS signaling for HE-AAC/HE-AACv2, if
+	// any bits remain. Running out of data here just means there's no
+	// extension config, not an error.
+	var syncExtensionType uint
+	if syncExtensionType, err = br.ReadBits(11); err != nil {
+		err = nil
+		return
+	}
+	if syncExtensionType != syncExtensionTypeSBR {
+		return
+	}
+
+	var extensionObjectType uint
+	if extensionObjectType, err = br.ReadBits(5); err != nil {
+		err = nil
+		return
+	}
+	if extensionObjectType != AOT_SBR {
+		return
+	}
+
+	var sbrPresent uint
+	if sbrPresent, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	if sbrPresent == 0 {
+		return
+	}
+	config.ExtensionObjectType = extensionObjectType
+	if config.ExtensionSampleRateIndex, _, _, err = readSampleRateIndex(br); err != nil {
+		err = nil
+		return
+	}
+
+	var syncExtensionType2 uint
+	if syncExtensionType2, err = br.ReadBits(11); err != nil {
+		err = nil
+		return
+	}
+	if syncExtensionType2 != syncExtensionTypePS {
+		return
+	}
+
+	var psPresent uint
+	if psPresent, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	config.PSPresent = psPresent == 1
+
 	return
 }
 
 func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 	bw := &bits.Writer{W: w}
 
-	if err = writeObjectType(bw, config.ObjectType); err != nil {
+	var pos, n uint
+	if n, err = writeObjectType(bw, config.ObjectType); err != nil {
 		return
 	}
+	pos += n
 
 	if config.SampleRateIndex == 0 {
 		for i, rate := range sampleRateTable {
@@ -222,11 +955,12 @@ func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 			}
 		}
 	}
-	if err = writeSampleRateIndex(bw, config.SampleRateIndex); err != nil {
+	if n, err = writeSampleRateIndex(bw, config.SampleRateIndex); err != nil {
 		return
 	}
+	pos += n
 
-	if config.ChannelConfig == 0 {
+	if config.PCE == nil && config.ChannelConfig == 0 {
 		for i, count := range chanConfigTable {
 			if count == config.ChannelCount {
 				config.ChannelConfig = uint(i)
@@ -236,6 +970,43 @@ func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 	if err = bw.WriteBits(config.ChannelConfig, 4); err != nil {
 		return
 	}
+	pos += 4
+
+	if config.ChannelConfig == 0 && config.PCE != nil {
+		if err = alignWriteBits(bw, &pos); err != nil {
+			return
+		}
+		if err = writePCE(bw, *config.PCE, &pos); err != nil {
+			return
+		}
+		if err = bw.FlushBits(); err != nil {
+			return
+		}
+		return
+	}
+
+	if config.ExtensionObjectType != 0 {
+		if err = bw.WriteBits(syncExtensionTypeSBR, 11); err != nil {
+			return
+		}
+		if err = bw.WriteBits(config.ExtensionObjectType, 5); err != nil {
+			return
+		}
+		if err = bw.WriteBits(1, 1); err != nil {
+			return
+		}
+		if _, err = writeSampleRateIndex(bw, config.ExtensionSampleRateIndex); err != nil {
+			return
+		}
+		if config.PSPresent {
+			if err = bw.WriteBits(syncExtensionTypePS, 11); err != nil {
+				return
+			}
+			if err = bw.WriteBits(1, 1); err != nil {
+				return
+			}
+		}
+	}
 
 	if err = bw.FlushBits(); err != nil {
 		return
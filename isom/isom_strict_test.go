@@ -0,0 +1,106 @@
+package isom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// withLenient runs fn with the package-level Lenient toggle set to v,
+// restoring the previous value afterwards.
+func withLenient(v bool, fn func()) {
+	old := Lenient
+	Lenient = v
+	defer func() { Lenient = old }()
+	fn()
+}
+
+func TestValidateRejectsReserved(t *testing.T) {
+	cases := []struct {
+		name   string
+		config MPEG4AudioConfig
+	}{
+		{
+			name:   "reserved object type",
+			config: MPEG4AudioConfig{ObjectType: 0, SampleRateIndex: 4, ChannelConfig: 2},
+		},
+		{
+			name:   "reserved sample rate index",
+			config: MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 13, ChannelConfig: 2},
+		},
+		{
+			name:   "reserved channel config",
+			config: MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 4, ChannelConfig: 9},
+		},
+		{
+			name:   "channel config 0 without a PCE",
+			config: MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 4, ChannelConfig: 0},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.config.Validate(); err == nil {
+				t.Errorf("Validate() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestValidateAcceptsEscapedSampleRateAndInlinePCE(t *testing.T) {
+	config := MPEG4AudioConfig{
+		ObjectType:             AOT_AAC_LC,
+		SampleRateIndex:        90000, // result of the 24-bit escape, far above len(sampleRateTable)
+		SampleRateIndexEscaped: true,
+		ChannelConfig:          0,
+		PCE:                    &PCE{ChannelCount: 2},
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestReadADTSHeaderStrictModeAcceptsChannelConfigZero(t *testing.T) {
+	// The ADTS fixed header can't carry a PCE, so a ChannelConfig of 0 must
+	// be accepted in Strict mode rather than rejected for lacking one.
+	config := MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 4, ChannelConfig: 0}
+	buf := &bytes.Buffer{}
+	if err := WriteADTSHeader(buf, config, 0); err != nil {
+		t.Fatalf("WriteADTSHeader: %v", err)
+	}
+
+	withLenient(false, func() {
+		if _, err := ReadADTSHeader(buf.Bytes()); err != nil {
+			t.Errorf("ReadADTSHeader() = %v, want nil", err)
+		}
+	})
+}
+
+func TestReadADTSHeaderStrictModeRejectsReservedSampleRate(t *testing.T) {
+	config := MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 13, ChannelConfig: 2}
+	buf := &bytes.Buffer{}
+	if err := WriteADTSHeader(buf, config, 0); err != nil {
+		t.Fatalf("WriteADTSHeader: %v", err)
+	}
+
+	withLenient(false, func() {
+		if _, err := ReadADTSHeader(buf.Bytes()); err != ErrADTSReservedSampleRate {
+			t.Errorf("ReadADTSHeader() = %v, want ErrADTSReservedSampleRate", err)
+		}
+	})
+}
+
+func TestSplitADTSRejectsMismatchAgainstRef(t *testing.T) {
+	frame := MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 4, ChannelConfig: 2}
+	ref := MPEG4AudioConfig{ObjectType: AOT_AAC_LC, SampleRateIndex: 4, ChannelConfig: 1}
+
+	buf := &bytes.Buffer{}
+	if err := WriteADTSHeader(buf, frame, 0); err != nil {
+		t.Fatalf("WriteADTSHeader: %v", err)
+	}
+
+	withLenient(false, func() {
+		err := SplitADTS(buf, &ref, func(c MPEG4AudioConfig, p []byte) error { return nil })
+		if err == nil {
+			t.Error("SplitADTS() = nil, want an error for a frame that does not match ref")
+		}
+	})
+}
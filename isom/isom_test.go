@@ -0,0 +1,189 @@
+package isom
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nareix/bits"
+)
+
+func TestSplitADTSRoundTrip(t *testing.T) {
+	config := MPEG4AudioConfig{
+		ObjectType:      AOT_AAC_LC,
+		SampleRateIndex: 4, // 44100
+		ChannelConfig:   2,
+	}
+	payload := bytes.Repeat([]byte{0x5a}, 37)
+
+	buf := &bytes.Buffer{}
+	if err := WriteADTSHeader(buf, config, len(payload)); err != nil {
+		t.Fatalf("WriteADTSHeader: %v", err)
+	}
+	buf.Write(payload)
+
+	var got []byte
+	err := SplitADTS(buf, nil, func(c MPEG4AudioConfig, p []byte) error {
+		if c.ObjectType != config.ObjectType || c.SampleRateIndex != config.SampleRateIndex || c.ChannelConfig != config.ChannelConfig {
+			t.Errorf("config = %+v, want ObjectType=%d SampleRateIndex=%d ChannelConfig=%d", c, config.ObjectType, config.SampleRateIndex, config.ChannelConfig)
+		}
+		got = p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SplitADTS: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %x, want %x", got, payload)
+	}
+}
+
+// writeADTSHeaderCRC builds a 9-byte CRC-present ADTS header. WriteADTSHeader
+// only ever emits the 7-byte protection_absent=1 form, so a CRC-present frame
+// has to be hand-built to exercise SplitADTS's 9-byte path.
+func writeADTSHeaderCRC(t *testing.T, w *bytes.Buffer, config MPEG4AudioConfig, payloadLen int, crc uint16) {
+	t.Helper()
+	bw := &bits.Writer{W: w}
+	must := func(err error) {
+		if err != nil {
+			t.Fatalf("writeADTSHeaderCRC: %v", err)
+		}
+	}
+	must(bw.WriteBits(0xfff, 12)) // syncword
+	must(bw.WriteBits(0, 1))      // MPEG version
+	must(bw.WriteBits(0, 2))      // layer
+	must(bw.WriteBits(0, 1))      // protection absent = 0, CRC present
+	must(bw.WriteBits(config.ObjectType-1, 2))
+	must(bw.WriteBits(config.SampleRateIndex, 4))
+	must(bw.WriteBits(0, 1)) // private bit
+	must(bw.WriteBits(config.ChannelConfig, 3))
+	must(bw.WriteBits(0, 1)) // originality
+	must(bw.WriteBits(0, 1)) // home
+	must(bw.WriteBits(0, 1)) // copyrighted id bit
+	must(bw.WriteBits(0, 1)) // copyright id start
+	must(bw.WriteBits(uint(9+payloadLen), 13))
+	must(bw.WriteBits(0x7ff, 11)) // buffer fullness
+	must(bw.WriteBits(0, 2))      // RDBs
+	must(bw.FlushBits())
+	must(bits.WriteUIntBE(w, uint(crc), 16))
+}
+
+func TestSplitADTSRoundTripCRCPresent(t *testing.T) {
+	config := MPEG4AudioConfig{
+		ObjectType:      AOT_AAC_LC,
+		SampleRateIndex: 4, // 44100
+		ChannelConfig:   2,
+	}
+	payload := bytes.Repeat([]byte{0xa5}, 23)
+
+	buf := &bytes.Buffer{}
+	writeADTSHeaderCRC(t, buf, config, len(payload), 0xbeef)
+	buf.Write(payload)
+
+	var got []byte
+	err := SplitADTS(buf, nil, func(c MPEG4AudioConfig, p []byte) error {
+		got = p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("SplitADTS: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("payload = %x, want %x", got, payload)
+	}
+}
+
+func TestMPEG4AudioConfigPCERoundTrip(t *testing.T) {
+	in := MPEG4AudioConfig{
+		ObjectType:      AOT_AAC_LC,
+		SampleRateIndex: 4, // 44100
+		ChannelConfig:   0,
+		PCE: &PCE{
+			ElementInstanceTag:     1,
+			ObjectType:             AOT_AAC_LC,
+			SamplingFrequencyIndex: 4,
+			FrontElements: []PCEChannelElement{
+				{IsCPE: true, Tag: 0},
+				{IsCPE: false, Tag: 1},
+			},
+			LFEElements: []uint{0},
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteMPEG4AudioConfig(buf, in); err != nil {
+		t.Fatalf("WriteMPEG4AudioConfig: %v", err)
+	}
+
+	out, err := ReadMPEG4AudioConfig(buf)
+	if err != nil {
+		t.Fatalf("ReadMPEG4AudioConfig: %v", err)
+	}
+
+	if out.ObjectType != in.ObjectType {
+		t.Errorf("ObjectType = %d, want %d", out.ObjectType, in.ObjectType)
+	}
+	if out.SampleRateIndex != in.SampleRateIndex {
+		t.Errorf("SampleRateIndex = %d, want %d", out.SampleRateIndex, in.SampleRateIndex)
+	}
+	if out.ChannelConfig != 0 {
+		t.Errorf("ChannelConfig = %d, want 0", out.ChannelConfig)
+	}
+	if out.PCE == nil {
+		t.Fatal("PCE = nil, want non-nil")
+	}
+	const wantChannelCount = 4 // 1 CPE front (2 channels) + 1 SCE front (1 channel) + 1 LFE
+	if out.PCE.ChannelCount != wantChannelCount {
+		t.Errorf("PCE.ChannelCount = %d, want %d", out.PCE.ChannelCount, wantChannelCount)
+	}
+	if len(out.PCE.FrontElements) != len(in.PCE.FrontElements) {
+		t.Fatalf("len(PCE.FrontElements) = %d, want %d", len(out.PCE.FrontElements), len(in.PCE.FrontElements))
+	}
+	for i, e := range in.PCE.FrontElements {
+		if out.PCE.FrontElements[i] != e {
+			t.Errorf("PCE.FrontElements[%d] = %+v, want %+v", i, out.PCE.FrontElements[i], e)
+		}
+	}
+	if len(out.PCE.LFEElements) != 1 || out.PCE.LFEElements[0] != 0 {
+		t.Errorf("PCE.LFEElements = %v, want [0]", out.PCE.LFEElements)
+	}
+}
+
+func TestMPEG4AudioConfigSBRPSRoundTrip(t *testing.T) {
+	in := MPEG4AudioConfig{
+		ObjectType:               AOT_AAC_LC,
+		SampleRateIndex:          8, // 16000, the "half rate" core typical of HE-AACv2
+		ChannelConfig:            2,
+		ExtensionObjectType:      AOT_SBR,
+		ExtensionSampleRateIndex: 4, // 44100, the SBR-extended rate
+		PSPresent:                true,
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteMPEG4AudioConfig(buf, in); err != nil {
+		t.Fatalf("WriteMPEG4AudioConfig: %v", err)
+	}
+
+	out, err := ReadMPEG4AudioConfig(buf)
+	if err != nil {
+		t.Fatalf("ReadMPEG4AudioConfig: %v", err)
+	}
+
+	if out.ObjectType != in.ObjectType {
+		t.Errorf("ObjectType = %d, want %d", out.ObjectType, in.ObjectType)
+	}
+	if out.SampleRateIndex != in.SampleRateIndex {
+		t.Errorf("SampleRateIndex = %d, want %d", out.SampleRateIndex, in.SampleRateIndex)
+	}
+	if out.ChannelConfig != in.ChannelConfig {
+		t.Errorf("ChannelConfig = %d, want %d", out.ChannelConfig, in.ChannelConfig)
+	}
+	if out.ExtensionObjectType != AOT_SBR {
+		t.Errorf("ExtensionObjectType = %d, want AOT_SBR", out.ExtensionObjectType)
+	}
+	if out.ExtensionSampleRateIndex != in.ExtensionSampleRateIndex {
+		t.Errorf("ExtensionSampleRateIndex = %d, want %d", out.ExtensionSampleRateIndex, in.ExtensionSampleRateIndex)
+	}
+	if !out.PSPresent {
+		t.Error("PSPresent = false, want true")
+	}
+}
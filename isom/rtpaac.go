@@ -0,0 +1,175 @@
+package isom
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/nareix/bits"
+)
+
+// auHeaderBytes is the byte-aligned size of one AU-header: a 13-bit
+// AU-size plus a 3-bit AU-index/AU-index-delta.
+const auHeaderBytes = 2
+
+// BuildAACHbrFMTP builds the fmtp attribute value describing an RTP
+// mpeg4-generic (RFC 3640, mode AAC-hbr) payload carrying AAC access units
+// encoded per config.
+func BuildAACHbrFMTP(config MPEG4AudioConfig) (fmtp string, err error) {
+	buf := &bytes.Buffer{}
+	if err = WriteMPEG4AudioConfig(buf, config); err != nil {
+		return
+	}
+	fmtp = fmt.Sprintf(
+		"streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=%s",
+		hex.EncodeToString(buf.Bytes()),
+	)
+	return
+}
+
+// PackAUs packs AAC access units into RTP mpeg4-generic (AAC-hbr) payloads,
+// each no larger than mtu bytes as long as mtu is above 4; with an mtu of 4 or
+// less an AU that doesn't fit is sent whole in one payload. Otherwise an AU
+// that doesn't fit a single payload is
+// fragmented across consecutive payloads, each carrying its own AU-header
+// declaring the AU's full size. The caller is responsible for stamping the
+// RTP marker bit (set on the last payload of every AU) and the RTP
+// timestamp, which does not advance across fragments of the same AU.
+func PackAUs(aus [][]byte, mtu int) (payloads [][]byte, err error) {
+	var headers []aacHbrHeader
+	var bodies [][]byte
+	size := 2 // AU-headers-length field
+
+	flush := func() error {
+		if len(headers) == 0 {
+			return nil
+		}
+		payload, err := writeAACHbrPayload(headers, bodies)
+		if err != nil {
+			return err
+		}
+		payloads = append(payloads, payload)
+		headers = nil
+		bodies = nil
+		size = 2
+		return nil
+	}
+
+	for _, au := range aus {
+		if 2+auHeaderBytes+len(au) > mtu {
+			// Too big for even its own payload, fragment it.
+			if err = flush(); err != nil {
+				return
+			}
+			for offset := 0; offset < len(au); {
+				chunk := mtu - 2 - auHeaderBytes
+				if chunk <= 0 || offset+chunk > len(au) {
+					chunk = len(au) - offset
+				}
+				var index uint
+				if offset != 0 {
+					index = 1
+				}
+				hdr := aacHbrHeader{size: uint(len(au)), index: index}
+				var payload []byte
+				if payload, err = writeAACHbrPayload([]aacHbrHeader{hdr}, [][]byte{au[offset : offset+chunk]}); err != nil {
+					return
+				}
+				payloads = append(payloads, payload)
+				offset += chunk
+			}
+			continue
+		}
+
+		if size+auHeaderBytes+len(au) > mtu {
+			if err = flush(); err != nil {
+				return
+			}
+		}
+		var index uint
+		if len(headers) != 0 {
+			index = 1
+		}
+		headers = append(headers, aacHbrHeader{size: uint(len(au)), index: index})
+		bodies = append(bodies, au)
+		size += auHeaderBytes + len(au)
+	}
+	if err = flush(); err != nil {
+		return
+	}
+
+	return
+}
+
+type aacHbrHeader struct {
+	size  uint
+	index uint
+}
+
+func writeAACHbrPayload(headers []aacHbrHeader, bodies [][]byte) (payload []byte, err error) {
+	buf := &bytes.Buffer{}
+	if err = bits.WriteUIntBE(buf, uint(len(headers))*16, 16); err != nil {
+		return
+	}
+	bw := &bits.Writer{W: buf}
+	for _, h := range headers {
+		if err = bw.WriteBits(h.size, 13); err != nil {
+			return
+		}
+		if err = bw.WriteBits(h.index, 3); err != nil {
+			return
+		}
+	}
+	if err = bw.FlushBits(); err != nil {
+		return
+	}
+	for _, body := range bodies {
+		buf.Write(body)
+	}
+	payload = buf.Bytes()
+	return
+}
+
+// UnpackAUs is the inverse of PackAUs: given one RTP mpeg4-generic (AAC-hbr)
+// payload, it returns the access unit(s) it carries. When the payload is a
+// fragment of a larger AU, the returned slice holds only the bytes present
+// in this payload; the caller reassembles fragments across packets using
+// the declared AU size and the RTP marker bit/timestamp.
+func UnpackAUs(payload []byte) (aus [][]byte, err error) {
+	if len(payload) < 2 {
+		err = fmt.Errorf("isom: rtp aac payload too short")
+		return
+	}
+
+	headersLenBits := uint(payload[0])<<8 | uint(payload[1])
+	headersLenBytes := (headersLenBits + 7) / 8
+	if 2+int(headersLenBytes) > len(payload) {
+		err = fmt.Errorf("isom: rtp aac au-headers-length overruns payload")
+		return
+	}
+
+	br := &bits.Reader{R: bytes.NewReader(payload[2 : 2+headersLenBytes])}
+	var sizes []uint
+	for n := headersLenBits / 16; n > 0; n-- {
+		var size uint
+		if size, err = br.ReadBits(13); err != nil {
+			return
+		}
+		if _, err = br.ReadBits(3); err != nil {
+			return
+		}
+		sizes = append(sizes, size)
+	}
+
+	data := payload[2+int(headersLenBytes):]
+	offset := 0
+	for _, size := range sizes {
+		end := offset + int(size)
+		if end > len(data) {
+			end = len(data)
+		}
+		aus = append(aus, data[offset:end])
+		offset = end
+	}
+
+	return
+}
@@ -0,0 +1,66 @@
+package isom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPackUnpackAUsRoundTrip(t *testing.T) {
+	aus := [][]byte{
+		bytes.Repeat([]byte{0x11}, 100),
+		bytes.Repeat([]byte{0x22}, 50),
+		bytes.Repeat([]byte{0x33}, 30),
+	}
+
+	payloads, err := PackAUs(aus, 1400)
+	if err != nil {
+		t.Fatalf("PackAUs: %v", err)
+	}
+	if len(payloads) != 1 {
+		t.Fatalf("len(payloads) = %d, want 1 (all AUs fit one mtu-sized payload)", len(payloads))
+	}
+
+	got, err := UnpackAUs(payloads[0])
+	if err != nil {
+		t.Fatalf("UnpackAUs: %v", err)
+	}
+	if len(got) != len(aus) {
+		t.Fatalf("len(got) = %d, want %d", len(got), len(aus))
+	}
+	for i, au := range aus {
+		if !bytes.Equal(got[i], au) {
+			t.Errorf("au[%d] = %x, want %x", i, got[i], au)
+		}
+	}
+}
+
+func TestPackUnpackAUsFragmented(t *testing.T) {
+	// An AU bigger than the mtu must be split across multiple payloads, each
+	// carrying an AU-header declaring the AU's full (unfragmented) size.
+	au := bytes.Repeat([]byte{0x44}, 300)
+	mtu := 100
+
+	payloads, err := PackAUs([][]byte{au}, mtu)
+	if err != nil {
+		t.Fatalf("PackAUs: %v", err)
+	}
+	if len(payloads) < 2 {
+		t.Fatalf("len(payloads) = %d, want at least 2 fragments", len(payloads))
+	}
+
+	var reassembled []byte
+	for i, payload := range payloads {
+		frags, err := UnpackAUs(payload)
+		if err != nil {
+			t.Fatalf("UnpackAUs(fragment %d): %v", i, err)
+		}
+		if len(frags) != 1 {
+			t.Fatalf("UnpackAUs(fragment %d) returned %d AUs, want 1", i, len(frags))
+		}
+		reassembled = append(reassembled, frags[0]...)
+	}
+
+	if !bytes.Equal(reassembled, au) {
+		t.Errorf("reassembled AU = %x, want %x", reassembled, au)
+	}
+}